@@ -0,0 +1,43 @@
+/*
+
+This package provides a client helper for talking to a dumby server
+running with MuxMode enabled.
+
+Instead of opening a new TCP connection for every session, DialMux opens
+one and multiplexes as many sessions as needed on top of it through
+yamux streams.
+
+*/
+
+package client
+
+import (
+	"net"
+
+	"github.com/hashicorp/yamux"
+)
+
+// StreamDialer opens a new logical connection to the server every time
+// it is called. Each one maps to its own dumby session on the server.
+type StreamDialer = func() (net.Conn, error)
+
+// DialMux dials address over TCP and opens a client-side yamux session on
+// top of it. The returned StreamDialer opens a new yamux stream per call,
+// so callers can cheaply create additional sessions without opening new
+// sockets.
+func DialMux(address string) (StreamDialer, error) {
+	conn, err := net.Dial("tcp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	muxSession, err := yamux.Client(conn, yamux.DefaultConfig())
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return muxSession.Open, nil
+}
@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// nonceSize is the size of a ChaCha20-Poly1305 nonce.
+const nonceSize = 12
+
+// Cipher seals and opens a Trame's Payload for the encrypt-then-MAC
+// framing used once a session's handshake has completed. The 48-byte
+// header is never covered by a Cipher: it stays cleartext so the Server
+// can still route by Session without decrypting anything. A nil Cipher
+// means the Payload travels as-is, which is how Parse, Trame.Read and
+// Trame.WriteTo behave before a session has negotiated one.
+type Cipher interface {
+	// Seal encrypts and authenticates payload, returning the sealed
+	// bytes to put on the wire in its place.
+	Seal(payload []byte) ([]byte, error)
+	// Open authenticates and decrypts a sealed payload read off the
+	// wire, returning the original payload.
+	Open(sealed []byte) ([]byte, error)
+}
+
+// AEADCipher is a Cipher backed by a cipher.AEAD (ChaCha20-Poly1305 in
+// practice) with a monotonically increasing nonce, mirroring the nonce
+// discipline of SSH's streamPacketCipher.etm: every Seal/Open call uses
+// the next sequence number, so the two ends of a session must each hold
+// their own AEADCipher keyed from HKDF with a direction-specific label.
+type AEADCipher struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+// NewAEADCipher wraps aead into a Cipher whose nonce counter starts at 0
+// and advances by one per Seal or Open call.
+func NewAEADCipher(aead cipher.AEAD) *AEADCipher {
+	return &AEADCipher{aead: aead}
+}
+
+func (c *AEADCipher) nextNonce() [nonceSize]byte {
+	var nonce [nonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[:8], atomic.AddUint64(&c.nonce, 1)-1)
+	return nonce
+}
+
+// Seal implements Cipher.
+func (c *AEADCipher) Seal(payload []byte) ([]byte, error) {
+	nonce := c.nextNonce()
+	return c.aead.Seal(nil, nonce[:], payload, nil), nil
+}
+
+// Open implements Cipher.
+func (c *AEADCipher) Open(sealed []byte) ([]byte, error) {
+	nonce := c.nextNonce()
+	return c.aead.Open(sealed[:0], nonce[:], sealed, nil)
+}
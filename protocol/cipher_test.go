@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func newTestAEADCipher(t *testing.T) *AEADCipher {
+	key := make([]byte, chacha20poly1305.KeySize)
+
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	aead, err := chacha20poly1305.New(key)
+
+	if err != nil {
+		t.Fatalf("failed to build AEAD: %v", err)
+	}
+
+	return NewAEADCipher(aead)
+}
+
+func TestAEADCipherSealOpenRoundtrip(t *testing.T) {
+	sealer := newTestAEADCipher(t)
+	opener := &AEADCipher{aead: sealer.aead}
+
+	payload := []byte("dumby payload")
+
+	sealed, err := sealer.Seal(payload)
+
+	if err != nil {
+		t.Fatalf("Seal returned an error: %v", err)
+	}
+
+	opened, err := opener.Open(sealed)
+
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+
+	if !bytes.Equal(opened, payload) {
+		t.Fatalf("roundtrip mismatch: got %q, want %q", opened, payload)
+	}
+}
+
+func TestAEADCipherNonceAdvancesEachCall(t *testing.T) {
+	sealer := newTestAEADCipher(t)
+
+	first, err := sealer.Seal([]byte("one"))
+
+	if err != nil {
+		t.Fatalf("Seal returned an error: %v", err)
+	}
+
+	second, err := sealer.Seal([]byte("one"))
+
+	if err != nil {
+		t.Fatalf("Seal returned an error: %v", err)
+	}
+
+	if bytes.Equal(first, second) {
+		t.Fatal("sealing the same payload twice should not produce the same ciphertext, the nonce must advance")
+	}
+}
+
+func TestAEADCipherOpenOutOfOrderFails(t *testing.T) {
+	sealer := newTestAEADCipher(t)
+	opener := &AEADCipher{aead: sealer.aead}
+
+	if _, err := sealer.Seal([]byte("one")); err != nil {
+		t.Fatalf("Seal returned an error: %v", err)
+	}
+
+	second, err := sealer.Seal([]byte("two"))
+
+	if err != nil {
+		t.Fatalf("Seal returned an error: %v", err)
+	}
+
+	// opener's nonce counter starts at 0, matching the first sealed
+	// payload's nonce, so trying to open second (sealed under nonce 1)
+	// out of order must fail authentication: both sides must advance
+	// their nonce counters in lockstep.
+	if _, err := opener.Open(second); err == nil {
+		t.Fatal("expected Open to fail when the nonce counters are out of sync")
+	}
+}
@@ -0,0 +1,30 @@
+package protocol
+
+import "sync"
+
+// BufferPool is a pool of byte buffers used to avoid allocating a new buffer
+// for every Trame read from or written to the wire.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// NewBufferPool creates a BufferPool whose buffers are all sized bufSize.
+func NewBufferPool(bufSize int) *BufferPool {
+	return &BufferPool{
+		pool: sync.Pool{
+			New: func() interface{} {
+				return make([]byte, bufSize)
+			},
+		},
+	}
+}
+
+// GetBytes checks out a buffer from the pool.
+func (p *BufferPool) GetBytes() []byte {
+	return p.pool.Get().([]byte)
+}
+
+// PutBytes returns a buffer to the pool so it can be reused.
+func (p *BufferPool) PutBytes(buf []byte) {
+	p.pool.Put(buf)
+}
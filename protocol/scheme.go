@@ -13,6 +13,8 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+	"io"
+	"sync"
 )
 
 const (
@@ -25,6 +27,9 @@ const (
 const (
 	// ActionInit is the action sent at the initialization of the session.
 	ActionInit = iota + 1
+	// ActionError is reserved for trames that carry a handler failure
+	// instead of normal response data; see server.Recover.
+	ActionError
 )
 
 // Session is a 256 bits ID.
@@ -70,11 +75,19 @@ func New(session Session, action Action, payload Payload) *Trame {
 	}
 }
 
-// Parse converts bytes to a Trame.
-func Parse(data []byte, trame *Trame) error {
+// Parse converts bytes to a Trame. cipher, if not nil, is used to Open
+// the wire payload before it is handed back as trame.Payload; pass nil
+// for trames that travel in the clear, such as the handshake itself.
+// The Trame's Payload aliases data directly instead of being copied, so
+// if data comes from a BufferPool it must stay untouched for as long as
+// the Trame (and whoever reads its Payload) is in use. With an
+// AEADCipher, Open decrypts in place over that same backing array: the
+// payload bytes of data are overwritten with the plaintext, which
+// Payload still aliases.
+func Parse(data []byte, trame *Trame, cipher Cipher) error {
 	dataLen := len(data)
 
-	if dataLen < 48 {
+	if dataLen < HeaderSize {
 		return errors.New("The data are too short for being a correct trame")
 	}
 
@@ -89,38 +102,102 @@ func Parse(data []byte, trame *Trame) error {
 	}
 
 	payloadSizeBuffer := (data)[40:48]
-	payloadSize, err := bytesToUint64(&payloadSizeBuffer)
+	wireSize, err := bytesToUint64(&payloadSizeBuffer)
 
 	if err != nil {
 		return errors.New("Cannot parse the trame's payload size")
 	}
 
-	if dataLen < int(48+payloadSize) {
+	if dataLen < int(HeaderSize+wireSize) {
 		return errors.New("The payload size specified is incorrect")
 	}
 
-	payload := (data)[48 : 48+payloadSize]
+	payload := (data)[HeaderSize : HeaderSize+wireSize]
+
+	if cipher != nil {
+		payload, err = cipher.Open(payload)
+
+		if err != nil {
+			return err
+		}
+	}
 
 	trame.Session = sessionBuffer
 	trame.Action = action
-	trame.PayloadSize = payloadSize
+	trame.PayloadSize = uint64(len(payload))
 	trame.Payload = payload
 
 	return nil
 }
 
-func (trame *Trame) Read(buffer []byte) int {
-	serialBuffer := make([]byte, 48+len(trame.Payload))
+// Read serializes the Trame into buffer, sealing Payload with cipher
+// first when it is not nil, and returns the number of bytes written.
+func (trame *Trame) Read(buffer []byte, cipher Cipher) (int, error) {
+	payload := []byte(trame.Payload)
+
+	if cipher != nil {
+		sealed, err := cipher.Seal(payload)
+
+		if err != nil {
+			return 0, err
+		}
+
+		payload = sealed
+	}
+
+	serialBuffer := make([]byte, HeaderSize+len(payload))
 
 	copy(serialBuffer, (trame.Session)[:])
 
 	binary.LittleEndian.PutUint64(serialBuffer[32:40], trame.Action)
 
-	binary.LittleEndian.PutUint64(serialBuffer[40:48], trame.PayloadSize)
+	binary.LittleEndian.PutUint64(serialBuffer[40:48], uint64(len(payload)))
 
-	copy(serialBuffer[48:48+trame.PayloadSize], trame.Payload)
+	copy(serialBuffer[48:], payload)
 
 	copy(buffer, serialBuffer)
 
-	return len(serialBuffer)
+	return len(serialBuffer), nil
+}
+
+// headerPool holds the scratch headers WriteTo serializes into, so that
+// handing a header to an io.Writer does not allocate one per call.
+var headerPool = sync.Pool{
+	New: func() interface{} { return new([HeaderSize]byte) },
+}
+
+// WriteTo serializes the Trame straight to w, without Read's intermediate
+// allocate-then-copy into the caller's buffer: the header is built in a
+// pooled scratch buffer and the payload is written from the Trame as-is.
+// cipher, if not nil, seals Payload before it goes on the wire and the
+// header's payload size reflects the sealed length, not trame.PayloadSize.
+func (trame *Trame) WriteTo(w io.Writer, cipher Cipher) (int64, error) {
+	payload := []byte(trame.Payload)
+
+	if cipher != nil {
+		sealed, err := cipher.Seal(payload)
+
+		if err != nil {
+			return 0, err
+		}
+
+		payload = sealed
+	}
+
+	header := headerPool.Get().(*[HeaderSize]byte)
+	defer headerPool.Put(header)
+
+	copy(header[:32], (trame.Session)[:])
+	binary.LittleEndian.PutUint64(header[32:40], trame.Action)
+	binary.LittleEndian.PutUint64(header[40:48], uint64(len(payload)))
+
+	n, err := w.Write(header[:])
+
+	if err != nil {
+		return int64(n), err
+	}
+
+	m, err := w.Write(payload)
+
+	return int64(n + m), err
 }
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"encoding/binary"
+	"io"
 	"math/rand"
 	"testing"
 )
@@ -57,7 +58,7 @@ func TestSerialize(t *testing.T) {
 
 	serialBuffer := make([]byte, 48+len(payload))
 
-	trame.Read(serialBuffer)
+	trame.Read(serialBuffer, nil)
 
 	dataLen := len(serialBuffer)
 	expectedLen := 48 + len(payload)
@@ -110,11 +111,11 @@ func TestParse(t *testing.T) {
 
 	serialBuffer := make([]byte, 48+len(payload))
 
-	trame.Read(serialBuffer)
+	trame.Read(serialBuffer, nil)
 
 	parsedTrame := new(Trame)
 
-	err = Parse(serialBuffer, parsedTrame)
+	err = Parse(serialBuffer, parsedTrame, nil)
 
 	if err != nil {
 		t.Error(err)
@@ -140,3 +141,55 @@ func TestParse(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func newBenchTrame() *Trame {
+	return New(Session{}, 101, Payload("Hello World!"))
+}
+
+// BenchmarkRead measures the old allocate-then-copy serialization path.
+func BenchmarkRead(b *testing.B) {
+	trame := newBenchTrame()
+	buf := make([]byte, HeaderSize+len(trame.Payload))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		trame.Read(buf, nil)
+	}
+}
+
+// BenchmarkWriteTo measures the zero-copy serialization path: it should
+// show far fewer allocs/op than BenchmarkRead.
+func BenchmarkWriteTo(b *testing.B) {
+	trame := newBenchTrame()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		trame.WriteTo(io.Discard, nil)
+	}
+}
+
+// BenchmarkParse measures parsing with a buffer pulled from a BufferPool,
+// which is how the server's reader goroutine uses it on the hot path.
+func BenchmarkParse(b *testing.B) {
+	trame := newBenchTrame()
+	pool := NewBufferPool(MaxTrameSize)
+	buf := pool.GetBytes()
+	n, err := trame.Read(buf, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	parsed := new(Trame)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := Parse(buf[:n], parsed, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
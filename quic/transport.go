@@ -0,0 +1,96 @@
+/*
+
+This package implements server.Transport on top of QUIC.
+
+A QUIC connection already multiplexes many streams, so unlike the TCP
+path there is no need to layer yamux on top: every stream accepted from
+every QUIC connection is handed out as its own logical connection, each
+one carrying a single dumby session.
+
+*/
+
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+// Transport accepts QUIC streams and hands each of them out as a logical
+// connection, so it satisfies server.Transport without importing the
+// server package (which would create an import cycle).
+type Transport struct {
+	listener *quicgo.Listener
+	streams  chan quicgo.Stream
+	closed   chan struct{}
+}
+
+// Listen starts a QUIC listener on address and returns a Transport ready
+// to be passed to server.Server.ListenTransport.
+func Listen(address string, tlsConfig *tls.Config, quicConfig *quicgo.Config) (*Transport, error) {
+	listener, err := quicgo.ListenAddr(address, tlsConfig, quicConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transport{
+		listener: listener,
+		streams:  make(chan quicgo.Stream),
+		closed:   make(chan struct{}),
+	}
+
+	go t.acceptConns()
+
+	return t, nil
+}
+
+func (t *Transport) acceptConns() {
+	for {
+		conn, err := t.listener.Accept(context.Background())
+
+		if err != nil {
+			return
+		}
+
+		go t.acceptStreams(conn)
+	}
+}
+
+func (t *Transport) acceptStreams(conn quicgo.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+
+		if err != nil {
+			return
+		}
+
+		select {
+		case t.streams <- stream:
+		case <-t.closed:
+			stream.Close()
+			return
+		}
+	}
+}
+
+// Accept returns the next QUIC stream, each one carrying its own dumby
+// session, just like a yamux stream would on the TCP path.
+func (t *Transport) Accept() (io.ReadWriteCloser, error) {
+	select {
+	case stream := <-t.streams:
+		return stream, nil
+	case <-t.closed:
+		return nil, errors.New("quic: transport is closed")
+	}
+}
+
+// Close stops the listener from accepting any further connection or stream.
+func (t *Transport) Close() error {
+	close(t.closed)
+	return t.listener.Close()
+}
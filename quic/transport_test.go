@@ -0,0 +1,131 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+func generateTestTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := x509.Certificate{SerialNumber: big.NewInt(1)}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+
+	if err != nil {
+		t.Fatalf("failed to build the key pair: %v", err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"dumby-test"}}
+}
+
+func TestTransportAcceptHandsOutEachStreamAsAConnection(t *testing.T) {
+	serverTLSConfig := generateTestTLSConfig(t)
+
+	transport, err := Listen("127.0.0.1:0", serverTLSConfig, nil)
+
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	defer transport.Close()
+
+	clientTLSConfig := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"dumby-test"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := quicgo.DialAddr(ctx, transport.listener.Addr().String(), clientTLSConfig, nil)
+
+	if err != nil {
+		t.Fatalf("DialAddr failed: %v", err)
+	}
+
+	defer conn.CloseWithError(0, "")
+
+	clientStream, err := conn.OpenStreamSync(ctx)
+
+	if err != nil {
+		t.Fatalf("OpenStreamSync failed: %v", err)
+	}
+
+	if _, err := clientStream.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write on the client stream: %v", err)
+	}
+
+	serverStream, err := transport.Accept()
+
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	buf := make([]byte, len("hello"))
+
+	if _, err := serverStream.Read(buf); err != nil {
+		t.Fatalf("failed to read on the accepted stream: %v", err)
+	}
+
+	if !bytes.Equal(buf, []byte("hello")) {
+		t.Fatalf("read %q, want %q", buf, "hello")
+	}
+}
+
+func TestTransportCloseUnblocksAccept(t *testing.T) {
+	transport, err := Listen("127.0.0.1:0", generateTestTLSConfig(t), nil)
+
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := transport.Accept()
+		done <- err
+	}()
+
+	if err := transport.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected Accept to return an error once the Transport is closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
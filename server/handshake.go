@@ -0,0 +1,158 @@
+package server
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+// x25519PublicKeySize is the size of an X25519 public key, and so of the
+// ActionInit payload a client sends when Config.AuthenticatedInit is on.
+const x25519PublicKeySize = 32
+
+// sessionCiphers holds the two independent Cipher values a session uses
+// once AuthenticatedInit has completed: rx opens trames coming from the
+// client, tx seals trames going to it. They are keyed separately, via
+// HKDF labels "dumby c2s" and "dumby s2c", so a compromised direction
+// does not leak the other one's keystream.
+type sessionCiphers struct {
+	rx protocol.Cipher
+	tx protocol.Cipher
+}
+
+// GenerateIdentity creates an Ed25519 keypair suitable for
+// Config.IdentityKey. Operators are expected to generate this once,
+// publish the public half to clients out of band, and keep reusing the
+// same private key across restarts so existing clients keep pinning the
+// same identity.
+func GenerateIdentity() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// handshake performs the server half of the AuthenticatedInit exchange
+// described on Config: trame is the client's ActionInit trame, whose
+// Payload must be its ephemeral X25519 public key. On success it stores
+// the session's rx/tx Ciphers and returns the trame to send back, whose
+// Payload is the server's ephemeral public key, the assigned
+// protocol.Session and an Ed25519 signature over the server's and the
+// client's ephemeral public keys.
+func (s *Server) handshake(trame *protocol.Trame, sessionID protocol.Session) (*protocol.Trame, error) {
+	clientPub := trame.Payload
+
+	if len(clientPub) != x25519PublicKeySize {
+		return nil, errors.New("server: ActionInit payload is not a valid X25519 public key")
+	}
+
+	curve := ecdh.X25519()
+
+	clientKey, err := curve.NewPublicKey(clientPub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serverKey, err := curve.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := serverKey.ECDH(clientKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serverPub := serverKey.PublicKey().Bytes()
+
+	rxKey, txKey, err := deriveSessionKeys(shared, clientPub, serverPub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rxAEAD, err := chacha20poly1305.New(rxKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	txAEAD, err := chacha20poly1305.New(txKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.sessionCiphers[sessionID] = &sessionCiphers{
+		rx: protocol.NewAEADCipher(rxAEAD),
+		tx: protocol.NewAEADCipher(txAEAD),
+	}
+	s.mutex.Unlock()
+
+	signed := append(append([]byte{}, serverPub...), clientPub...)
+	signature := ed25519.Sign(s.config.IdentityKey, signed)
+
+	payload := make([]byte, 0, x25519PublicKeySize+len(sessionID)+ed25519.SignatureSize)
+	payload = append(payload, serverPub...)
+	payload = append(payload, sessionID[:]...)
+	payload = append(payload, signature...)
+
+	return protocol.New(sessionID, protocol.ActionInit, payload), nil
+}
+
+// deriveSessionKeys runs HKDF-SHA256 over the X25519 shared secret twice,
+// once per direction, so the client-to-server and server-to-client
+// traffic keys are independent even though they come from the same
+// shared secret.
+func deriveSessionKeys(shared, clientPub, serverPub []byte) (rxKey, txKey []byte, err error) {
+	salt := append(append([]byte{}, clientPub...), serverPub...)
+
+	rxKey = make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("dumby c2s")), rxKey); err != nil {
+		return nil, nil, err
+	}
+
+	txKey = make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("dumby s2c")), txKey); err != nil {
+		return nil, nil, err
+	}
+
+	return rxKey, txKey, nil
+}
+
+// rxCipherFor returns the Cipher that opens trames received from
+// session's client, or nil if no handshake has completed for it yet.
+func (s *Server) rxCipherFor(session protocol.Session) protocol.Cipher {
+	s.mutex.RLock()
+	ciphers, exist := s.sessionCiphers[session]
+	s.mutex.RUnlock()
+
+	if !exist {
+		return nil
+	}
+
+	return ciphers.rx
+}
+
+// txCipherFor returns the Cipher that seals trames sent to session's
+// client, or nil if no handshake has completed for it yet.
+func (s *Server) txCipherFor(session protocol.Session) protocol.Cipher {
+	s.mutex.RLock()
+	ciphers, exist := s.sessionCiphers[session]
+	s.mutex.RUnlock()
+
+	if !exist {
+		return nil
+	}
+
+	return ciphers.tx
+}
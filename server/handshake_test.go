@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+func TestDeriveSessionKeysRoundtrip(t *testing.T) {
+	curve := ecdh.X25519()
+
+	clientKey, err := curve.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+
+	serverKey, err := curve.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("failed to generate server key: %v", err)
+	}
+
+	clientShared, err := clientKey.ECDH(serverKey.PublicKey())
+
+	if err != nil {
+		t.Fatalf("client ECDH failed: %v", err)
+	}
+
+	serverShared, err := serverKey.ECDH(clientKey.PublicKey())
+
+	if err != nil {
+		t.Fatalf("server ECDH failed: %v", err)
+	}
+
+	clientPub := clientKey.PublicKey().Bytes()
+	serverPub := serverKey.PublicKey().Bytes()
+
+	// The client derives its own rx/tx the other way round from the
+	// server: it transmits with the c2s key and receives with the s2c
+	// key, the opposite of deriveSessionKeys' rx/tx naming, which is
+	// from the server's perspective.
+	clientTxKey, clientRxKey, err := deriveSessionKeys(clientShared, clientPub, serverPub)
+
+	if err != nil {
+		t.Fatalf("client deriveSessionKeys failed: %v", err)
+	}
+
+	serverRxKey, serverTxKey, err := deriveSessionKeys(serverShared, clientPub, serverPub)
+
+	if err != nil {
+		t.Fatalf("server deriveSessionKeys failed: %v", err)
+	}
+
+	if !bytes.Equal(clientTxKey, serverRxKey) {
+		t.Fatal("client's tx key must match server's rx key")
+	}
+
+	if !bytes.Equal(clientRxKey, serverTxKey) {
+		t.Fatal("client's rx key must match server's tx key")
+	}
+}
+
+func TestHandshakeStoresSessionCiphers(t *testing.T) {
+	pub, priv, err := GenerateIdentity()
+
+	if err != nil {
+		t.Fatalf("GenerateIdentity failed: %v", err)
+	}
+
+	_ = pub
+
+	s := New(&Config{AuthenticatedInit: true, IdentityKey: priv})
+
+	curve := ecdh.X25519()
+	clientKey, err := curve.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+
+	sessionID := protocol.Session{1, 2, 3}
+	initTrame := protocol.New(protocol.Session{}, protocol.ActionInit, clientKey.PublicKey().Bytes())
+
+	reply, err := s.handshake(initTrame, sessionID)
+
+	if err != nil {
+		t.Fatalf("handshake returned an error: %v", err)
+	}
+
+	if reply.Session != sessionID {
+		t.Fatalf("reply trame carries session %x, want %x", reply.Session, sessionID)
+	}
+
+	if s.rxCipherFor(sessionID) == nil || s.txCipherFor(sessionID) == nil {
+		t.Fatal("handshake should have stored both the rx and tx Ciphers for the session")
+	}
+}
+
+func TestHandshakeRejectsWrongSizedPayload(t *testing.T) {
+	_, priv, err := GenerateIdentity()
+
+	if err != nil {
+		t.Fatalf("GenerateIdentity failed: %v", err)
+	}
+
+	s := New(&Config{AuthenticatedInit: true, IdentityKey: priv})
+
+	initTrame := protocol.New(protocol.Session{}, protocol.ActionInit, []byte("too short"))
+
+	if _, err := s.handshake(initTrame, protocol.Session{1}); err == nil {
+		t.Fatal("expected handshake to reject a payload that isn't a valid X25519 public key")
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+// Middleware wraps a Handler with extra behavior, e.g. logging or panic
+// recovery. See Server.Use, Recover and Logger.
+type Middleware = func(Handler) Handler
+
+// Recover returns a Middleware that turns a handler panic into a trame
+// sent on protocol.ActionError instead of crashing the goroutine the
+// handler runs in. The trame's Payload is fmt.Sprint(recover()).
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(trame *protocol.Trame, w ResponseWriter) {
+			defer func() {
+				r := recover()
+
+				if r == nil {
+					return
+				}
+
+				rs, ok := w.(rawSender)
+
+				if !ok {
+					return
+				}
+
+				errWriter := newResponseWriter(&protocol.Trame{Session: trame.Session, Action: protocol.ActionError}, rs.rawChan())
+				errWriter.Write([]byte(fmt.Sprint(r)))
+				errWriter.Close()
+			}()
+
+			next(trame, w)
+		}
+	}
+}
+
+// Logger returns a Middleware that logs every trame a handler processes
+// to logger, along with how long the handler took to return.
+func Logger(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(trame *protocol.Trame, w ResponseWriter) {
+			start := time.Now()
+
+			next(trame, w)
+
+			logger.Printf("action=%d session=%x payload=%dB took=%s", trame.Action, trame.Session, trame.PayloadSize, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+func TestRecoverMiddlewareTurnsPanicIntoActionError(t *testing.T) {
+	send := make(chan *protocol.Trame, 1)
+	trame := protocol.New(protocol.Session{1}, protocol.Action(3), nil)
+	w := newResponseWriter(trame, send)
+
+	handler := Recover()(func(trame *protocol.Trame, w ResponseWriter) {
+		panic("boom")
+	})
+
+	handler(trame, w)
+
+	reply := <-send
+
+	if reply.Action != protocol.ActionError {
+		t.Fatalf("expected the recovered panic to be sent as ActionError, got %d", reply.Action)
+	}
+
+	if reply.Session != trame.Session {
+		t.Fatalf("error trame carries session %x, want %x", reply.Session, trame.Session)
+	}
+
+	if !bytes.Equal(reply.Payload, []byte("boom")) {
+		t.Fatalf("error trame payload is %q, want %q", reply.Payload, "boom")
+	}
+}
+
+func TestRecoverMiddlewareLetsNonPanickingHandlersThrough(t *testing.T) {
+	send := make(chan *protocol.Trame, 1)
+	trame := protocol.New(protocol.Session{1}, protocol.Action(3), nil)
+	w := newResponseWriter(trame, send)
+
+	var called bool
+	handler := Recover()(func(trame *protocol.Trame, w ResponseWriter) {
+		called = true
+	})
+
+	handler(trame, w)
+
+	if !called {
+		t.Fatal("expected the wrapped handler to run")
+	}
+
+	select {
+	case reply := <-send:
+		t.Fatalf("expected no trame to be sent, got %+v", reply)
+	default:
+	}
+}
+
+func TestLoggerMiddlewareLogsAfterTheHandlerRuns(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+
+	send := make(chan *protocol.Trame, 1)
+	trame := protocol.New(protocol.Session{1}, protocol.Action(3), nil)
+	w := newResponseWriter(trame, send)
+
+	var called bool
+	handler := Logger(logger)(func(trame *protocol.Trame, w ResponseWriter) {
+		called = true
+	})
+
+	handler(trame, w)
+
+	if !called {
+		t.Fatal("expected the wrapped handler to run")
+	}
+
+	if buf.Len() == 0 {
+		t.Fatal("expected Logger to write a log line")
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yanishoss/dumby/client"
+	"github.com/yanishoss/dumby/protocol"
+)
+
+const testEchoAction protocol.Action = 42
+
+func readTrame(t *testing.T, r *bufio.Reader) *protocol.Trame {
+	t.Helper()
+
+	buf := make([]byte, protocol.MaxTrameSize)
+	size, err := r.Read(buf)
+
+	if err != nil {
+		t.Fatalf("failed to read a trame: %v", err)
+	}
+
+	trame := new(protocol.Trame)
+
+	if err := protocol.Parse(buf[:size], trame, nil); err != nil {
+		t.Fatalf("failed to parse a trame: %v", err)
+	}
+
+	return trame
+}
+
+func TestMuxModeGivesEachStreamItsOwnSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	// MaxConnections must be set explicitly: New only fills in its 10000
+	// default when called with no Config at all, so an overridden Config
+	// that omits it would otherwise leave every connection beyond the
+	// first rejected as soon as the connections map is non-empty.
+	s := New(&Config{MuxMode: true, MaxConnections: 10000})
+	s.AddHandlers(testEchoAction, func(trame *protocol.Trame, w ResponseWriter) {
+		w.Write(trame.Payload)
+		w.Close()
+	})
+
+	go s.ListenTransport(NewTCPTransport(listener))
+	defer listener.Close()
+
+	dial, err := client.DialMux(listener.Addr().String())
+
+	if err != nil {
+		t.Fatalf("DialMux failed: %v", err)
+	}
+
+	stream1, err := dial()
+
+	if err != nil {
+		t.Fatalf("failed to open the first stream: %v", err)
+	}
+
+	stream2, err := dial()
+
+	if err != nil {
+		t.Fatalf("failed to open the second stream: %v", err)
+	}
+
+	defer stream1.Close()
+	defer stream2.Close()
+
+	// Generous on purpose: under -race the scheduler can fall well
+	// behind wall-clock time, especially across a repeated -count run,
+	// and this deadline is only a hang guard, not a correctness check.
+	stream1.SetDeadline(time.Now().Add(20 * time.Second))
+	stream2.SetDeadline(time.Now().Add(20 * time.Second))
+
+	if _, err := protocol.New(protocol.Session{}, protocol.ActionInit, nil).WriteTo(stream1, nil); err != nil {
+		t.Fatalf("failed to send ActionInit on stream1: %v", err)
+	}
+
+	if _, err := protocol.New(protocol.Session{}, protocol.ActionInit, nil).WriteTo(stream2, nil); err != nil {
+		t.Fatalf("failed to send ActionInit on stream2: %v", err)
+	}
+
+	reader1 := bufio.NewReader(stream1)
+	reader2 := bufio.NewReader(stream2)
+
+	session1 := readTrame(t, reader1).Session
+	session2 := readTrame(t, reader2).Session
+
+	if session1 == session2 {
+		t.Fatal("expected each yamux stream to be assigned a distinct session")
+	}
+
+	if _, err := protocol.New(session1, testEchoAction, []byte("hello")).WriteTo(stream1, nil); err != nil {
+		t.Fatalf("failed to send the echo trame: %v", err)
+	}
+
+	reply := readTrame(t, reader1)
+
+	if !bytes.Equal(reply.Payload, []byte("hello")) {
+		t.Fatalf("echoed payload is %q, want %q", reply.Payload, "hello")
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+// maxResponsePayload is the largest Payload a response trame can carry,
+// i.e. a full protocol.MaxTrameSize trame minus its header.
+const maxResponsePayload = protocol.MaxTrameSize - protocol.HeaderSize
+
+// ResponseWriter lets a Handler stream a response of any size back to
+// the session that triggered it, without building protocol.Trames by
+// hand. Write chunks payload into protocol.MaxTrameSize-sized trames as
+// needed, all carrying the triggering trame's Session and Action.
+type ResponseWriter interface {
+	// Write appends payload to the response, flushing a full trame each
+	// time the buffered data, plus a header, reaches
+	// protocol.MaxTrameSize. It always
+	// consumes the whole payload or returns an error.
+	Write(payload []byte) (int, error)
+	// Flush sends whatever has been written so far as a trame, even if
+	// it is smaller than protocol.MaxTrameSize.
+	Flush() error
+	// Close flushes any remaining buffered data and marks the
+	// ResponseWriter unusable for further writes.
+	Close() error
+}
+
+// rawSender exposes the channel a ResponseWriter writes trames to, so
+// Adapt can hand it to a ChanHandler unchanged.
+type rawSender interface {
+	rawChan() chan<- *protocol.Trame
+}
+
+// trameResponseWriter is the ResponseWriter every Handler is actually
+// given; it is unexported since callers only need the interface.
+type trameResponseWriter struct {
+	session protocol.Session
+	action  protocol.Action
+	send    chan<- *protocol.Trame
+	buf     []byte
+	closed  bool
+}
+
+func newResponseWriter(trame *protocol.Trame, send chan<- *protocol.Trame) *trameResponseWriter {
+	return &trameResponseWriter{session: trame.Session, action: trame.Action, send: send}
+}
+
+// Write implements ResponseWriter.
+func (w *trameResponseWriter) Write(payload []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("server: write to a closed ResponseWriter")
+	}
+
+	w.buf = append(w.buf, payload...)
+
+	for len(w.buf) >= maxResponsePayload {
+		w.send <- protocol.New(w.session, w.action, w.buf[:maxResponsePayload:maxResponsePayload])
+		w.buf = append([]byte(nil), w.buf[maxResponsePayload:]...)
+	}
+
+	return len(payload), nil
+}
+
+// Flush implements ResponseWriter.
+func (w *trameResponseWriter) Flush() error {
+	if w.closed {
+		return errors.New("server: flush of a closed ResponseWriter")
+	}
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	w.send <- protocol.New(w.session, w.action, w.buf)
+	w.buf = nil
+
+	return nil
+}
+
+// Close implements ResponseWriter.
+func (w *trameResponseWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	err := w.Flush()
+	w.closed = true
+
+	return err
+}
+
+func (w *trameResponseWriter) rawChan() chan<- *protocol.Trame {
+	return w.send
+}
+
+// ChanHandler is the Handler signature from before ResponseWriter
+// existed: it writes whole trames straight onto the Server's upcoming
+// data channel instead of streaming a payload. Wrap one with Adapt to
+// register it via Server.AddHandlers.
+type ChanHandler = func(trame *protocol.Trame, s chan<- *protocol.Trame)
+
+// Adapt wraps a ChanHandler into a Handler, so handlers written against
+// the old channel-based signature keep working unmodified. The
+// ChanHandler gets the exact channel a Handler's ResponseWriter would
+// otherwise chunk writes onto, so it stays free to send arbitrarily
+// constructed trames.
+func Adapt(h ChanHandler) Handler {
+	return func(trame *protocol.Trame, w ResponseWriter) {
+		rs, ok := w.(rawSender)
+
+		if !ok {
+			return
+		}
+
+		h(trame, rs.rawChan())
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+func drainSendChan(ch chan *protocol.Trame) []*protocol.Trame {
+	var trames []*protocol.Trame
+
+	for {
+		select {
+		case trame := <-ch:
+			trames = append(trames, trame)
+		default:
+			return trames
+		}
+	}
+}
+
+func TestResponseWriterFlushesFullTramesAsTheyFill(t *testing.T) {
+	send := make(chan *protocol.Trame, 10)
+	trame := protocol.New(protocol.Session{1}, protocol.Action(3), nil)
+	w := newResponseWriter(trame, send)
+
+	payload := bytes.Repeat([]byte("a"), maxResponsePayload+10)
+
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("Write returned an error: %v", err)
+	}
+
+	sent := drainSendChan(send)
+
+	if len(sent) != 1 {
+		t.Fatalf("expected exactly one full trame to be flushed, got %d", len(sent))
+	}
+
+	if len(sent[0].Payload) != maxResponsePayload {
+		t.Fatalf("flushed trame has %d bytes of payload, want %d", len(sent[0].Payload), maxResponsePayload)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	remainder := drainSendChan(send)
+
+	if len(remainder) != 1 || len(remainder[0].Payload) != 10 {
+		t.Fatalf("expected Close to flush the remaining 10 bytes, got %+v", remainder)
+	}
+}
+
+func TestResponseWriterWriteAfterCloseFails(t *testing.T) {
+	send := make(chan *protocol.Trame, 1)
+	trame := protocol.New(protocol.Session{1}, protocol.Action(3), nil)
+	w := newResponseWriter(trame, send)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	if _, err := w.Write([]byte("too late")); err == nil {
+		t.Fatal("expected Write on a closed ResponseWriter to fail")
+	}
+}
+
+func TestAdaptGivesTheChanHandlerTheRawChannel(t *testing.T) {
+	send := make(chan *protocol.Trame, 1)
+	trame := protocol.New(protocol.Session{1}, protocol.Action(3), nil)
+	w := newResponseWriter(trame, send)
+
+	var gotChan chan<- *protocol.Trame
+	handler := Adapt(func(trame *protocol.Trame, s chan<- *protocol.Trame) {
+		gotChan = s
+	})
+
+	handler(trame, w)
+
+	if gotChan == nil {
+		t.Fatal("expected Adapt to hand the ChanHandler the ResponseWriter's raw channel")
+	}
+}
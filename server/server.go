@@ -3,8 +3,8 @@
 This package provides a concurrent server implementation for the protocol.
 
 The architecture is really simple, you assign for each action a handler or several.
-When the client send an action, the trame sent is dispatched to the handler with a channel.
-This channel allows you to respond back to the client, multiple times if you want.
+When the client send an action, the trame sent is dispatched to the handler with a ResponseWriter.
+This ResponseWriter allows you to stream a response back to the client of any size, in multiple trames if needed.
 
 The inner implementation is made of 2 concepts:
 	- the Dispatch routine whose job is to handle the data flows.
@@ -19,10 +19,11 @@ How to use ?
 func main() {
 	s := server.New()
 
-	s.AddHandlers(3, func(trame *protocol.Trame, s chan<- *protocol.Trame) {
+	s.AddHandlers(3, func(trame *protocol.Trame, w server.ResponseWriter) {
 		fmt.Println(string(trame.Payload))
 
-		s <- []byte("Hello World!")
+		w.Write([]byte("Hello World!"))
+		w.Close()
 	})
 
 	s.Listen("localhost:4000")
@@ -34,33 +35,84 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"errors"
 	"io"
 	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 
+	"github.com/hashicorp/yamux"
 	"github.com/yanishoss/dumby/protocol"
 )
 
-// Handler is a function that handles the trames of a specific action
-type Handler = func(trame *protocol.Trame, s chan<- *protocol.Trame)
+// Handler is a function that handles the trames of a specific action. w
+// lets the handler stream back a response of any size without building
+// Trames by hand; see ResponseWriter. Handlers built against the older
+// channel-based signature still work, wrapped with Adapt.
+type Handler = func(trame *protocol.Trame, w ResponseWriter)
 
 // Config handles the Server's configuration
 type Config struct {
 	MaxConnections uint
+	// MuxMode wraps every accepted connection in a server-side yamux
+	// session. Instead of tying one TCP connection to exactly one dumby
+	// session, each yamux stream gets its own ActionInit handshake and its
+	// own protocol.Session, so a client behind a single NAT/proxy socket
+	// can still run many sessions concurrently.
+	MuxMode bool
+	// RxBytesPerSec, TxBytesPerSec, RxTramesPerSec and TxTramesPerSec set
+	// the global Valve limits shared by every session that has no
+	// per-session Valve of its own (see Server.SetSessionValve). 0 means
+	// unlimited.
+	RxBytesPerSec  uint
+	TxBytesPerSec  uint
+	RxTramesPerSec uint
+	TxTramesPerSec uint
+	// OnThrottle, if set, is called every time a session is made to wait
+	// on a Valve because it ran out of tokens.
+	OnThrottle func(session protocol.Session, direction Direction)
+	// AuthenticatedInit turns ActionInit into an X25519 key exchange: the
+	// client's init payload is its ephemeral public key, and the Server
+	// replies with its own ephemeral public key, the assigned
+	// protocol.Session and an Ed25519 signature over both ephemeral public
+	// keys, so the client can pin the Server's long-term identity. Every trame after that is
+	// framed encrypt-then-MAC with a ChaCha20-Poly1305 key HKDF-derived
+	// from the shared secret, independently per direction. See
+	// handshake.go. IdentityKey must be set when this is on.
+	AuthenticatedInit bool
+	// IdentityKey is the Server's long-term Ed25519 signing key. It is
+	// signed into every handshake when AuthenticatedInit is set, so a
+	// client that already knows the Server's public key can detect a
+	// man-in-the-middle. See GenerateIdentity.
+	IdentityKey ed25519.PrivateKey
 }
 
 // Server contains all the elements that allow the architecture to works correctly
 type Server struct {
-	config      *Config
-	connections mapSessionToConnection
-	listener    *net.TCPListener
-	handlers    mapActionToHandlers
-	r           chan *protocol.Trame // r is the channel of the incoming data
-	s           chan *protocol.Trame // s is the channel of the upcoming data
-	mutex       *sync.RWMutex
+	config         *Config
+	connections    mapSessionToConnection
+	transport      Transport
+	handlers       mapActionToHandlers
+	middlewares    []Middleware
+	r              chan *incomingTrame  // r is the channel of the incoming data
+	s              chan *protocol.Trame // s is the channel of the upcoming data
+	bufferPool     *protocol.BufferPool
+	globalValve    *Valve
+	sessionValves  map[protocol.Session]*Valve
+	sessionCiphers map[protocol.Session]*sessionCiphers
+	mutex          *sync.RWMutex
+}
+
+// incomingTrame pairs a parsed Trame with the pooled buffer backing its
+// Payload slice, so the buffer can be returned to the pool once every
+// handler notified of the Trame is done reading it.
+type incomingTrame struct {
+	trame *protocol.Trame
+	buf   []byte
 }
 
 type mapSessionToConnection = map[protocol.Session]chan *protocol.Trame
@@ -77,24 +129,34 @@ func New(config ...*Config) *Server {
 	defaultConfig := &Config{
 		MaxConnections: 10000,
 	}
-	connections := make(mapSessionToConnection)
-	listener := new(net.TCPListener)
-	handlers := make(mapActionToHandlers)
-	r := make(chan *protocol.Trame)
-	s := make(chan *protocol.Trame)
-	mutex := new(sync.RWMutex)
 
 	if len(config) > 0 {
 		defaultConfig = config[0]
 	}
 
+	connections := make(mapSessionToConnection)
+	var transport Transport
+	handlers := make(mapActionToHandlers)
+	r := make(chan *incomingTrame)
+	s := make(chan *protocol.Trame)
+	bufferPool := protocol.NewBufferPool(protocol.MaxTrameSize)
+	globalValve := NewValve(defaultConfig.RxBytesPerSec, defaultConfig.TxBytesPerSec, defaultConfig.RxTramesPerSec, defaultConfig.TxTramesPerSec)
+	sessionValves := make(map[protocol.Session]*Valve)
+	sessionCiphers := make(map[protocol.Session]*sessionCiphers)
+	mutex := new(sync.RWMutex)
+
 	return &Server{
 		defaultConfig,
 		connections,
-		listener,
+		transport,
 		handlers,
+		nil,
 		r,
 		s,
+		bufferPool,
+		globalValve,
+		sessionValves,
+		sessionCiphers,
 		mutex,
 	}
 }
@@ -116,7 +178,16 @@ func (s *Server) AddHandlers(action protocol.Action, handlers ...Handler) {
 	s.mutex.Unlock()
 }
 
-// Listen launches the Server
+// Use registers middlewares that every Handler is wrapped with before it
+// runs, in the order given: the first Middleware passed is the outermost
+// one, so it sees a trame before the others and the response after them.
+func (s *Server) Use(mw ...Middleware) {
+	s.mutex.Lock()
+	s.middlewares = append(s.middlewares, mw...)
+	s.mutex.Unlock()
+}
+
+// Listen launches the Server over plain TCP.
 func (s *Server) Listen(address string) error {
 	listener, err := net.Listen("tcp", address)
 
@@ -124,46 +195,75 @@ func (s *Server) Listen(address string) error {
 		return err
 	}
 
-	s.listener = listener.(*net.TCPListener)
+	return s.ListenTransport(NewTCPTransport(listener))
+}
+
+// ListenTransport launches the Server on top of an already built Transport,
+// e.g. a quic.Transport, instead of the default plain TCP one.
+func (s *Server) ListenTransport(transport Transport) error {
+	s.transport = transport
 
 	// It is quite useless provided your server runs all the time
 	// It is here just for the case you stop the server accidentally
-	defer s.listener.Close()
+	defer s.transport.Close()
 
 	// Launch the Dispatch routine before accepting connections
 	go s.dispatch()
 
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := s.transport.Accept()
 
 		if err != nil {
 			continue
 		}
 
 		// Launch the Data Transfer routines
-		s.handleDataTransfer(conn.(*net.TCPConn))
+		go s.handleDataTransfer(conn)
 	}
 }
 
-func (s *Server) noticeHandlers(trame *protocol.Trame) {
+func (s *Server) noticeHandlers(it *incomingTrame) {
 	s.mutex.RLock()
+	handlers, exist := s.handlers[it.trame.Action]
+	middlewares := s.middlewares
+	s.mutex.RUnlock()
 
-	action := trame.Action
+	if !exist {
+		s.bufferPool.PutBytes(it.buf)
+		return
+	}
 
-	if handlers, exist := s.handlers[action]; exist {
-		for _, handler := range *handlers {
-			go handler(trame, s.s)
-		}
+	var wg sync.WaitGroup
+
+	for _, handler := range *handlers {
+		wg.Add(1)
+		go func(handler Handler) {
+			defer wg.Done()
+
+			for i := len(middlewares) - 1; i >= 0; i-- {
+				handler = middlewares[i](handler)
+			}
+
+			w := newResponseWriter(it.trame, s.s)
+			defer w.Close()
+
+			handler(it.trame, w)
+		}(handler)
 	}
 
-	s.mutex.RUnlock()
+	// Only return the buffer backing it.trame.Payload to the pool once
+	// every handler notified of it is done reading from it.
+	go func() {
+		wg.Wait()
+		s.bufferPool.PutBytes(it.buf)
+	}()
 }
 
 func (s *Server) dispatch() {
 	for {
 		select {
-		case trame := <-s.r:
-			go s.noticeHandlers(trame)
+		case it := <-s.r:
+			go s.noticeHandlers(it)
 		case trame := <-s.s:
 			go s.noticeDataRoutine(trame)
 		}
@@ -220,14 +320,44 @@ func (s *Server) initConnection(trame *protocol.Trame, send chan *protocol.Trame
 	s.connections[sessionID] = send
 	s.mutex.Unlock()
 
+	if s.config.AuthenticatedInit {
+		reply, err := s.handshake(trame, sessionID)
+
+		if err != nil {
+			s.mutex.Lock()
+			delete(s.connections, sessionID)
+			s.mutex.Unlock()
+			return protocol.Session{}, err
+		}
+
+		// Mirror the plaintext path below: handleIncomingData compares
+		// this against sessionID right after onInit returns, so the
+		// client's original ActionInit trame must carry the assigned
+		// session too, not just the reply.
+		trame.Session = sessionID
+
+		send <- reply
+
+		return sessionID, nil
+	}
+
 	trame.Session = sessionID
 
-	send <- trame
+	// Echo back a freshly allocated ack trame rather than forwarding
+	// trame itself: trame.Payload still aliases the BufferPool buffer
+	// handleIncomingData read it into, and that buffer is returned to
+	// the pool (for reuse by any other connection) as soon as
+	// noticeHandlers sees no handler registered for ActionInit, which is
+	// normally immediately. Sending trame as-is would race that reuse
+	// against handleUpcomingData still serializing it onto the wire.
+	ack := protocol.New(sessionID, trame.Action, append(protocol.Payload(nil), trame.Payload...))
+
+	send <- ack
 
 	return sessionID, nil
 }
 
-func (s *Server) kill(session protocol.Session, conn *net.TCPConn) error {
+func (s *Server) kill(session protocol.Session, conn io.ReadWriteCloser) error {
 	s.mutex.Lock()
 
 	if _, exist := s.connections[session]; exist {
@@ -235,19 +365,23 @@ func (s *Server) kill(session protocol.Session, conn *net.TCPConn) error {
 		delete(s.connections, session)
 	}
 
+	delete(s.sessionValves, session)
+	// Also drops the session's handshake Ciphers, so AuthenticatedInit
+	// sessions don't leak AEAD state; this relies on callers passing the
+	// real assigned session rather than a stale/zero one (see handleClose).
+	delete(s.sessionCiphers, session)
+
 	s.mutex.Unlock()
 	return conn.Close()
 }
 
-func (s *Server) handleIncomingData(conn *net.TCPConn, onInit func(trame *protocol.Trame) (protocol.Session, error), kill chan bool) {
+func (s *Server) handleIncomingData(ctx context.Context, cancel context.CancelFunc, conn io.ReadWriteCloser, onInit func(trame *protocol.Trame) (protocol.Session, error), kill chan bool) {
 	r := bufio.NewReader(conn)
 
 	receive := s.r
 
 	isInit := false
 
-	buf := make([]byte, r.Size())
-
 	var sessionID protocol.Session
 	for {
 		select {
@@ -256,29 +390,44 @@ func (s *Server) handleIncomingData(conn *net.TCPConn, onInit func(trame *protoc
 				return
 			}
 		default:
+			buf := s.bufferPool.GetBytes()
+
 			size, err := r.Read(buf)
 
 			if err == io.EOF || err == io.ErrClosedPipe || err == io.ErrUnexpectedEOF || size < protocol.HeaderSize {
+				s.bufferPool.PutBytes(buf)
+				cancel()
 				kill <- true
 				return
 			}
 
 			trame := new(protocol.Trame)
 
-			err = protocol.Parse(buf, trame)
+			// The handshake itself always travels in the clear: before
+			// isInit flips, no Cipher has been negotiated yet.
+			var rxCipher protocol.Cipher
+			if isInit {
+				rxCipher = s.rxCipherFor(sessionID)
+			}
+
+			err = protocol.Parse(buf[:size], trame, rxCipher)
 
 			if err != nil {
+				s.bufferPool.PutBytes(buf)
+				cancel()
 				kill <- true
 				return
 			}
 
 			// Skip until the client initializes the connection
 			if trame.Action != protocol.ActionInit && !isInit {
+				s.bufferPool.PutBytes(buf)
 				continue
 			}
 
 			// Skip because the connection is already initialized
 			if trame.Action == protocol.ActionInit && isInit {
+				s.bufferPool.PutBytes(buf)
 				continue
 			}
 
@@ -288,32 +437,58 @@ func (s *Server) handleIncomingData(conn *net.TCPConn, onInit func(trame *protoc
 				sessionID, err = onInit(trame)
 
 				if err != nil {
+					s.bufferPool.PutBytes(buf)
+					cancel()
 					kill <- true
 					return
 				}
 			}
 
 			if isInit && sessionID != trame.Session {
+				s.bufferPool.PutBytes(buf)
+				cancel()
 				s.kill(sessionID, conn)
 				return
 			}
 
-			receive <- trame
+			// Hold the trame back until the session's Valve has enough
+			// inbound tokens for it.
+			if err := s.valveFor(sessionID).allowRx(ctx, trame.PayloadSize, s.onThrottle(sessionID, Inbound)); err != nil {
+				s.bufferPool.PutBytes(buf)
+				cancel()
+				kill <- true
+				return
+			}
+
+			receive <- &incomingTrame{trame, buf}
 		}
 	}
 }
 
-func (s *Server) handleUpcomingData(conn *net.TCPConn, send <-chan *protocol.Trame, kill chan bool) {
+func (s *Server) handleUpcomingData(ctx context.Context, cancel context.CancelFunc, conn io.ReadWriteCloser, send <-chan *protocol.Trame, kill chan bool) {
 	for {
 		select {
 		case trame := <-send:
-			buf := make([]byte, protocol.HeaderSize+trame.PayloadSize)
+			// Hold the trame back until the session's Valve has enough
+			// outbound tokens for it.
+			if err := s.valveFor(trame.Session).allowTx(ctx, trame.PayloadSize, s.onThrottle(trame.Session, Outbound)); err != nil {
+				cancel()
+				kill <- true
+				continue
+			}
 
-			trame.Read(buf)
+			// The handshake reply must stay cleartext too: it is what
+			// carries the key material the txCipher would be sealed
+			// with.
+			var txCipher protocol.Cipher
+			if trame.Action != protocol.ActionInit {
+				txCipher = s.txCipherFor(trame.Session)
+			}
 
-			_, err := conn.Write(buf)
+			_, err := trame.WriteTo(conn, txCipher)
 
 			if err != nil {
+				cancel()
 				kill <- true
 			}
 		case isKilled := <-kill:
@@ -324,35 +499,81 @@ func (s *Server) handleUpcomingData(conn *net.TCPConn, send <-chan *protocol.Tra
 	}
 }
 
-func (s *Server) handleClose(session protocol.Session, conn *net.TCPConn, kill chan bool) {
+// handleClose waits for the kill signal and tears the connection down.
+// sessionID is read lazily, not captured by value, because onInit only
+// assigns the real session asynchronously from handleIncomingData: by
+// the time spawnDataTransferPair launches this goroutine, the session
+// usually is not known yet. If it never becomes known (the connection
+// never completed ActionInit), sessionID returns nil and the zero
+// Session is used, matching there never having been an entry to clean up.
+func (s *Server) handleClose(cancel context.CancelFunc, sessionID func() *protocol.Session, conn io.ReadWriteCloser, kill chan bool) {
 	for {
 		isKilled := <-kill
 
 		if isKilled {
+			cancel()
+
+			session := protocol.Session{}
+			if id := sessionID(); id != nil {
+				session = *id
+			}
+
 			s.kill(session, conn)
 			return
 		}
 	}
 }
 
-func (s *Server) handleDataTransfer(conn *net.TCPConn) {
+// handleDataTransfer prepares conn to carry dumby sessions. With MuxMode
+// off, conn itself carries exactly one session. With MuxMode on, conn is
+// the raw TCP connection and gets wrapped in a server-side yamux session,
+// and every yamux stream accepted on top of it carries its own session.
+func (s *Server) handleDataTransfer(conn io.ReadWriteCloser) {
+	if !s.config.MuxMode {
+		s.spawnDataTransferPair(conn)
+		return
+	}
+
+	muxSession, err := yamux.Server(conn, yamux.DefaultConfig())
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	for {
+		stream, err := muxSession.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go s.spawnDataTransferPair(stream)
+	}
+}
+
+// spawnDataTransferPair launches the Data Transfer routines that carry a
+// single dumby session over conn, which can be a plain *net.TCPConn or a
+// yamux stream.
+func (s *Server) spawnDataTransferPair(conn io.ReadWriteCloser) {
 	s.mutex.RLock()
 	if uint(len(s.connections)) <= s.config.MaxConnections {
 		s.mutex.RUnlock()
 		send := make(chan *protocol.Trame)
 		kill := make(chan bool)
-		var session protocol.Session
+		ctx, cancel := context.WithCancel(context.Background())
+		var session atomic.Pointer[protocol.Session]
 
 		// Uses for the connection initialization
 		onInit := func(trame *protocol.Trame) (protocol.Session, error) {
-			s, err := s.initConnection(trame, send)
-			session = s
-			return s, err
+			sessionID, err := s.initConnection(trame, send)
+			session.Store(&sessionID)
+			return sessionID, err
 		}
 
-		go s.handleIncomingData(conn, onInit, kill)
-		go s.handleUpcomingData(conn, send, kill)
-		go s.handleClose(session, conn, kill)
+		go s.handleIncomingData(ctx, cancel, conn, onInit, kill)
+		go s.handleUpcomingData(ctx, cancel, conn, send, kill)
+		go s.handleClose(cancel, session.Load, conn, kill)
 	} else {
 		s.mutex.RUnlock()
 		conn.Close()
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"io"
+	"net"
+)
+
+// Transport abstracts how the Server accepts new logical connections, so
+// the Data Transfer machinery is not tied to *net.TCPConn. Plain TCP
+// (optionally yamux-muxed) and QUIC both implement it.
+type Transport interface {
+	// Accept blocks until a new logical connection is available.
+	Accept() (io.ReadWriteCloser, error)
+	// Close stops the Transport from accepting any further connection.
+	Close() error
+}
+
+// tcpTransport is the default Transport, backed by a plain TCP listener.
+type tcpTransport struct {
+	listener net.Listener
+}
+
+// NewTCPTransport builds a Transport out of an already listening net.Listener.
+func NewTCPTransport(listener net.Listener) Transport {
+	return &tcpTransport{listener}
+}
+
+func (t *tcpTransport) Accept() (io.ReadWriteCloser, error) {
+	return t.listener.Accept()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.listener.Close()
+}
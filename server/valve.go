@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+// Direction tells an OnThrottle hook which flow was held back.
+type Direction int
+
+const (
+	// Inbound is data flowing from the client to the Server.
+	Inbound Direction = iota
+	// Outbound is data flowing from the Server to the client.
+	Outbound
+)
+
+// tokenBucket is a bucket that refills at ratePerSec and holds at most
+// ratePerSec tokens. A ratePerSec of 0 means unlimited: take always
+// succeeds immediately.
+type tokenBucket struct {
+	ratePerSec float64
+	tokens     float64
+	lastRefill time.Time
+	mutex      sync.Mutex
+}
+
+func newTokenBucket(ratePerSec uint) *tokenBucket {
+	rate := float64(ratePerSec)
+
+	return &tokenBucket{
+		ratePerSec: rate,
+		tokens:     rate,
+		lastRefill: time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.lastRefill = now
+
+	b.tokens += elapsed * b.ratePerSec
+
+	if b.tokens > b.ratePerSec {
+		b.tokens = b.ratePerSec
+	}
+}
+
+// take blocks until n tokens are available (or, for n above the bucket's
+// capacity, until the bucket is full), ctx is cancelled, or the bucket is
+// unlimited. onThrottle, if not nil, is called every time the
+// caller has to wait for tokens.
+func (b *tokenBucket) take(ctx context.Context, n float64, onThrottle func()) error {
+	if b.ratePerSec <= 0 {
+		return nil
+	}
+
+	// refill caps tokens at ratePerSec, so a request costing more than
+	// the bucket's own capacity could never satisfy tokens >= n and
+	// would spin forever. Instead let it through once the bucket is
+	// full, driving tokens negative: the deficit is paid back out of
+	// later refills, throttling whatever comes next until it is.
+	threshold := n
+	if threshold > b.ratePerSec {
+		threshold = b.ratePerSec
+	}
+
+	for {
+		b.mutex.Lock()
+		b.refill()
+
+		if b.tokens >= threshold {
+			b.tokens -= n
+			b.mutex.Unlock()
+			return nil
+		}
+
+		b.mutex.Unlock()
+
+		if onThrottle != nil {
+			onThrottle()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// Valve is a QoS gate made of independent token buckets for inbound and
+// outbound bytes and trames. A limit of 0 leaves that dimension
+// unthrottled. Valves can be set per-session to override the Server's
+// global limits.
+type Valve struct {
+	rxBytes  *tokenBucket
+	txBytes  *tokenBucket
+	rxTrames *tokenBucket
+	txTrames *tokenBucket
+}
+
+// NewValve creates a Valve from the given per-second limits.
+func NewValve(rxBytesPerSec, txBytesPerSec, rxTramesPerSec, txTramesPerSec uint) *Valve {
+	return &Valve{
+		rxBytes:  newTokenBucket(rxBytesPerSec),
+		txBytes:  newTokenBucket(txBytesPerSec),
+		rxTrames: newTokenBucket(rxTramesPerSec),
+		txTrames: newTokenBucket(txTramesPerSec),
+	}
+}
+
+func (v *Valve) allowRx(ctx context.Context, payloadSize protocol.PayloadSize, onThrottle func()) error {
+	if err := v.rxTrames.take(ctx, 1, onThrottle); err != nil {
+		return err
+	}
+
+	return v.rxBytes.take(ctx, float64(protocol.HeaderSize)+float64(payloadSize), onThrottle)
+}
+
+func (v *Valve) allowTx(ctx context.Context, payloadSize protocol.PayloadSize, onThrottle func()) error {
+	if err := v.txTrames.take(ctx, 1, onThrottle); err != nil {
+		return err
+	}
+
+	return v.txBytes.take(ctx, float64(protocol.HeaderSize)+float64(payloadSize), onThrottle)
+}
+
+// SetSessionValve overrides the Server's global Valve for a single
+// session. It is meant to be called from an ActionInit handler, once the
+// session has been assigned.
+func (s *Server) SetSessionValve(session protocol.Session, valve *Valve) {
+	s.mutex.Lock()
+	s.sessionValves[session] = valve
+	s.mutex.Unlock()
+}
+
+// valveFor returns the session's own Valve if one was set via
+// SetSessionValve, or the Server's global Valve otherwise.
+func (s *Server) valveFor(session protocol.Session) *Valve {
+	s.mutex.RLock()
+	valve, exist := s.sessionValves[session]
+	s.mutex.RUnlock()
+
+	if exist {
+		return valve
+	}
+
+	return s.globalValve
+}
+
+func (s *Server) onThrottle(session protocol.Session, direction Direction) func() {
+	return func() {
+		if s.config.OnThrottle != nil {
+			s.config.OnThrottle(session, direction)
+		}
+	}
+}
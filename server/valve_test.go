@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanishoss/dumby/protocol"
+)
+
+func TestValveAllowRxUnlimited(t *testing.T) {
+	v := NewValve(0, 0, 0, 0)
+
+	if err := v.allowRx(context.Background(), protocol.MaxTrameSize, nil); err != nil {
+		t.Fatalf("unlimited Valve should never block: %v", err)
+	}
+}
+
+func TestValveAllowTxBlocksThenUnblocksAsTokensRefill(t *testing.T) {
+	// 10 trames/sec and no byte limit, so only the trame bucket binds.
+	v := NewValve(0, 0, 0, 10)
+
+	for i := 0; i < 10; i++ {
+		if err := v.allowTx(context.Background(), 1, nil); err != nil {
+			t.Fatalf("trame %d: unexpected error: %v", i, err)
+		}
+	}
+
+	var throttled bool
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	if err := v.allowTx(ctx, 1, func() { throttled = true }); err != nil {
+		t.Fatalf("expected the bucket to refill before the deadline: %v", err)
+	}
+
+	if !throttled {
+		t.Fatal("expected onThrottle to fire while waiting for a token")
+	}
+}
+
+func TestValveAllowRxContextCancellation(t *testing.T) {
+	// 100 bytes/sec, so the bucket starts full at 100 tokens.
+	v := NewValve(100, 0, 0, 0)
+
+	if err := v.allowRx(context.Background(), 10, nil); err != nil {
+		t.Fatalf("first read should fit within the bucket's initial fill: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// What remains in the bucket can't cover this read, so allowRx must
+	// wait in take's retry loop and observe ctx.Done() instead of
+	// looping forever.
+	if err := v.allowRx(ctx, protocol.MaxTrameSize, nil); err == nil {
+		t.Fatal("expected allowRx to return the context's error once cancelled")
+	}
+}
+
+func TestValveForFallsBackToGlobal(t *testing.T) {
+	s := New()
+	session := protocol.Session{1}
+
+	if got := s.valveFor(session); got != s.globalValve {
+		t.Fatal("expected valveFor to return the global Valve before any override")
+	}
+
+	override := NewValve(1, 1, 1, 1)
+	s.SetSessionValve(session, override)
+
+	if got := s.valveFor(session); got != override {
+		t.Fatal("expected valveFor to return the session's own Valve after SetSessionValve")
+	}
+}